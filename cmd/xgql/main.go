@@ -22,20 +22,31 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/apollotracing"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	otelruntime "go.opentelemetry.io/contrib/instrumentation/runtime"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/metric/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/trace/jaeger"
+	"go.opentelemetry.io/otel/metric/global"
 	"go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -43,14 +54,20 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	kextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	kextclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	kextinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/crossplane/crossplane-runtime/pkg/logging"
 	extv1 "github.com/crossplane/crossplane/apis/apiextensions/v1"
 	pkgv1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	pkgv1beta1 "github.com/crossplane/crossplane/apis/pkg/v1beta1"
 
 	"github.com/upbound/xgql/internal/auth"
 	"github.com/upbound/xgql/internal/clients"
@@ -97,9 +114,27 @@ func main() {
 		tlsKey   = app.Flag("tls-key", "Path to the TLS key file used to serve TLS connections.").ExistingFile()
 		insecure = app.Flag("listen-insecure", "Address at which to listen for insecure connections.").Default("127.0.0.1:8080").String()
 		play     = app.Flag("enable-playground", "Serve a GraphQL Playground.").Bool()
-		tracer   = app.Flag("trace-backend", "Tracer to use.").Default("jaeger").Enum("jaeger", "gcp")
+		tracer   = app.Flag("trace-backend", "Tracer to use.").Default("jaeger").Enum("jaeger", "gcp", "otlp")
 		ratio    = app.Flag("trace-ratio", "Ratio of queries that should be traced.").Default("0.01").Float()
 		agent    = app.Flag("trace-agent", "Address of the Jaeger trace agent as [host]:[port]").TCP()
+
+		metricsBackend = app.Flag("metrics-backend", "Metrics exporter to use.").Default("prometheus").Enum("prometheus", "otlp")
+
+		otlpEndpoint = app.Flag("otlp-endpoint", "Endpoint (host:port) of the OTLP collector to send traces and/or metrics to.").String()
+		otlpInsecure = app.Flag("otlp-insecure", "Disable TLS when connecting to --otlp-endpoint.").Bool()
+		otlpHeaders  = app.Flag("otlp-header", "Extra header (e.g. an API key) to send with every OTLP export, as key=value. May be repeated.").StringMap()
+		otlpProtocol = app.Flag("otlp-protocol", "Protocol to speak to --otlp-endpoint when using the otlp trace backend.").Default("grpc").Enum("grpc", "http")
+
+		oidcIssuer      = app.Flag("oidc-issuer", "OIDC issuer URL used to verify bearer tokens. Leave unset to forward bearer tokens to the API server as-is.").String()
+		oidcClientID    = app.Flag("oidc-client-id", "OIDC client ID xgql was issued by the issuer.").String()
+		oidcAudience    = app.Flag("oidc-audience", "Expected audience of OIDC tokens, if different from --oidc-client-id.").String()
+		oidcJWKSRefresh = app.Flag("oidc-jwks-refresh", "Interval logged with the OIDC settings at startup. The issuer's JSON Web Key Set is refreshed only when a token names an unknown key ID, not on this interval.").Default("5m").Duration()
+
+		oidcImpersonate = app.Flag("oidc-impersonate", "Exchange OIDC tokens for Kubernetes impersonation headers rather than forwarding them to the API server as-is.").Bool()
+		oidcUserPrefix  = app.Flag("oidc-user-prefix", "Prepended to an OIDC token's subject to produce the impersonated username.").Default("oidc:").String()
+		oidcGroupPrefix = app.Flag("oidc-group-prefix", "Prepended to each of an OIDC token's groups to produce the impersonated group names.").Default("oidc:").String()
+
+		maxSubsPerIdentity = app.Flag("max-subscriptions-per-identity", "Maximum number of concurrent GraphQL subscriptions allowed per identity.").Default("100").Int()
 	)
 	app.Version(version.Version)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
@@ -113,11 +148,29 @@ func main() {
 	// 2021/06/08 10:03:26 <nil>
 	// This appears to be fixed with https://github.com/open-telemetry/opentelemetry-go/pull/1851
 	// However, there is no release for opentelemetry-go with this fix yet.
-	res := resource.NewWithAttributes(attribute.String("service.name", "crossplane.io/gql"))
+	res := resource.NewWithAttributes(
+		attribute.String("service.name", "crossplane.io/gql"),
+		attribute.String("service.version", version.Version),
+		attribute.String("service.instance.id", instanceID()),
+		attribute.String("k8s.namespace.name", os.Getenv("POD_NAMESPACE")),
+		attribute.String("k8s.pod.name", os.Getenv("POD_NAME")),
+	)
 
-	// OpenTelemetry metrics.
-	prom, err := prometheus.InstallNewPipeline(prometheus.Config{}, basic.WithResource(res))
-	kingpin.FatalIfError(err, "cannot create OpenTelemetry Prometheus exporter")
+	// OpenTelemetry metrics. prom is nil - and nothing is served at /metrics -
+	// when we're pushing metrics to an OTLP collector instead of letting
+	// Prometheus scrape us.
+	var prom *prometheus.Exporter
+	switch *metricsBackend {
+	case "otlp":
+		log.Debug("Enabling OTLP metrics exporter", "endpoint", *otlpEndpoint)
+		shutdown, err := newOTLPMetricsPipeline(context.Background(), res, *otlpEndpoint, *otlpInsecure, *otlpHeaders)
+		kingpin.FatalIfError(err, "cannot create OpenTelemetry OTLP metrics exporter")
+		defer shutdown()
+	default:
+		var err error
+		prom, err = prometheus.InstallNewPipeline(prometheus.Config{}, basic.WithResource(res))
+		kingpin.FatalIfError(err, "cannot create OpenTelemetry Prometheus exporter")
+	}
 
 	tpOpts := []trace.TracerProviderOption{
 		trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(*ratio))),
@@ -140,28 +193,63 @@ func main() {
 		_, shutdown, err := texporter.InstallNewPipeline([]texporter.Option{}, tpOpts...)
 		kingpin.FatalIfError(err, "cannot create OpenTelemetry GCP exporter")
 		defer shutdown()
+	case "otlp":
+		log.Debug("Enabling OTLP tracer", "endpoint", *otlpEndpoint, "protocol", *otlpProtocol)
+		exp, err := newOTLPSpanExporter(context.Background(), *otlpProtocol, *otlpEndpoint, *otlpInsecure, *otlpHeaders)
+		kingpin.FatalIfError(err, "cannot create OpenTelemetry OTLP exporter")
+		tp := trace.NewTracerProvider(append(tpOpts, trace.WithBatcher(exp))...)
+		defer func() {
+			kingpin.FatalIfError(tp.Shutdown(context.Background()), "cannot shutdown OTLP exporter")
+		}()
 	}
 
-	// NOTE(negz): This handler is called when a cache can't watch a type that
-	// it would like to, for example because the user doesn't have RBAC access
-	// to watch that type, or because it was defined by a CRD that is now gone.
-	// Ideally we'd terminate any cache in this state, but controller-runtime
-	// does not surface the configurable watch error handling of the underlying
-	// client-go machinery, so instead we just log it. The errors will persist
-	// until they are resolved (e.g. the user is granted the RBAC access they
-	// need) or the cache expires.
+	// NOTE(negz): This handler is called for any Kubernetes runtime error that
+	// isn't specific to one identity's cache, e.g. errors from the CRD
+	// watcher below. Per-identity watch errors (a user's RBAC access was
+	// revoked, or a CRD they were watching is gone) are instead handled by
+	// clients.Cache itself, which tears down and rebuilds the affected
+	// identity's cache rather than logging the same error until it expires.
 	utilruntime.ErrorHandlers = []func(error){func(err error) { log.Debug("Kubernetes runtime error", "err", err) }}
 
+	authOpts := []auth.Option{}
+	if *oidcIssuer != "" {
+		p, err := oidc.NewProvider(context.Background(), *oidcIssuer)
+		kingpin.FatalIfError(err, "cannot discover OIDC issuer")
+
+		aud := *oidcAudience
+		if aud == "" {
+			aud = *oidcClientID
+		}
+
+		// go-oidc's remote key set refreshes lazily - i.e. whenever it sees a
+		// key ID it doesn't recognise. The --oidc-jwks-refresh interval is
+		// only logged; nothing forces a refresh on it, so keys an issuer
+		// rotates without changing their ID are not picked up.
+		v := p.Verifier(&oidc.Config{ClientID: *oidcClientID, SkipClientIDCheck: *oidcClientID == ""})
+		log.Debug("Enabling OIDC authentication", "issuer", *oidcIssuer, "jwks-refresh", *oidcJWKSRefresh)
+
+		o := auth.OIDC{Verifier: v, Audience: aud}
+		if *oidcImpersonate {
+			log.Debug("Enabling OIDC impersonation", "user-prefix", *oidcUserPrefix, "group-prefix", *oidcGroupPrefix)
+			o.Impersonation = &auth.Impersonation{UserPrefix: *oidcUserPrefix, GroupPrefix: *oidcGroupPrefix}
+		}
+
+		authOpts = append(authOpts, auth.WithOIDC(o))
+	}
+
+	au := auth.NewAuthenticator(authOpts...)
+
 	rt := chi.NewRouter()
 	rt.Use(middleware.RequestLogger(&formatter{log}))
 	rt.Use(middleware.Compress(5)) // Chi recommends compression level 5.
-	rt.Use(auth.Middleware)
+	rt.Use(auth.NewMiddleware(authOpts...))
 	rt.Use(version.Middleware)
 
 	s := runtime.NewScheme()
 	kingpin.FatalIfError(corev1.AddToScheme(s), "cannot add Kubernetes core/v1 to scheme")
 	kingpin.FatalIfError(kextv1.AddToScheme(s), "cannot add Kubernetes apiextensions/v1 to scheme")
 	kingpin.FatalIfError(pkgv1.AddToScheme(s), "cannot add Crossplane pkg/v1 to scheme")
+	kingpin.FatalIfError(pkgv1beta1.AddToScheme(s), "cannot add Crossplane pkg/v1beta1 to scheme")
 	kingpin.FatalIfError(extv1.AddToScheme(s), "cannot add Crossplane apiextensions/v1 to scheme")
 	kingpin.FatalIfError(appsv1.AddToScheme(s), "cannot add Kubernetes apps/v1 to scheme")
 	kingpin.FatalIfError(rbacv1.AddToScheme(s), "cannot add Kubernetes rbac/v1 to scheme")
@@ -185,14 +273,48 @@ func main() {
 		clients.DoNotCache(noCache),
 		clients.WithLogger(log),
 	)
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolvers.New(ca)}))
+
+	// Tell ca to stop watching a GVK, for whichever identities' caches are
+	// watching it, when its backing CRD is deleted. We use our own
+	// credentials to watch CRDs, same as we do for the REST mapper above.
+	crdCtx, stopCRDWatch := context.WithCancel(context.Background())
+	defer stopCRDWatch()
+	kingpin.FatalIfError(watchCRDs(crdCtx, cfg, ca), "cannot watch custom resource definitions")
+
+	rr := resolvers.New(ca, resolvers.WithMaxSubscriptionsPerIdentity(*maxSubsPerIdentity))
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: rr}))
+	srv.AddTransport(&transport.Websocket{
+		// Negotiate gqlgen's subprotocol, falling back to the older one for
+		// clients (e.g. Apollo) that haven't moved to graphql-transport-ws.
+		KeepAlivePingInterval: 10 * time.Second,
+
+		// Browser clients can't set an Authorization header on the upgrade
+		// request, so they send a bearer token in the connection_init
+		// payload instead. Authenticate it the same way our HTTP middleware
+		// authenticates the header, so a subscription sees the same
+		// Credentials a query or mutation would.
+		InitFunc: func(ctx context.Context, initPayload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+			tok := strings.TrimPrefix(initPayload.Authorization(), "Bearer ")
+
+			creds, err := au.Authenticate(ctx, tok)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return auth.NewContext(ctx, creds), &initPayload, nil
+		},
+	})
 	srv.SetErrorPresenter(present.Error)
 	srv.Use(opentelemetry.MetricEmitter{})
 	srv.Use(opentelemetry.Tracer{})
 	srv.Use(apollotracing.Tracer{})
 
+	// The websocket transport registered above negotiates the subscription
+	// subprotocol itself, so subscriptions share this same handler and route.
 	rt.Handle("/query", otelhttp.NewHandler(srv, "/query"))
-	rt.Handle("/metrics", prom)
+	if prom != nil {
+		rt.Handle("/metrics", prom)
+	}
 	rt.Handle("/version", version.Handler())
 	if *play {
 		rt.Handle("/", playground.Handler("GraphQL playground", "/query"))
@@ -218,6 +340,106 @@ func main() {
 	kingpin.FatalIfError(h.ListenAndServe(), "cannot serve insecure HTTP")
 }
 
+// watchCRDs uses cfg (our own, system credentials) to watch for
+// CustomResourceDefinitions being deleted, and invalidates ca's cache of the
+// GVK(s) each one offered accordingly. This is what lets us stop serving a
+// GVK - and stop logging the resulting watch errors - as soon as its CRD
+// goes away, rather than only once every identity's cache expires.
+func watchCRDs(ctx context.Context, cfg *rest.Config, ca *clients.Cache) error {
+	cs, err := kextclientset.NewForConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "cannot create apiextensions client")
+	}
+
+	// We only invalidate on delete. ca.Invalidate already scopes its work to
+	// identities actually watching the GVK in question, but a CRD is added or
+	// updated far more often than it's deleted (e.g. once per reconcile of
+	// its controller, and once for every pre-existing CRD when this informer
+	// does its initial list on startup) and nothing about those events means
+	// an already-cached GVK needs to be forgotten.
+	invalidate := func(obj interface{}) {
+		crd, ok := obj.(*kextv1.CustomResourceDefinition)
+		if !ok {
+			return
+		}
+		for _, v := range crd.Spec.Versions {
+			ca.Invalidate(schema.GroupVersionKind{Group: crd.Spec.Group, Version: v.Name, Kind: crd.Spec.Names.Kind})
+		}
+	}
+
+	f := kextinformers.NewSharedInformerFactory(cs, 0)
+	inf := f.Apiextensions().V1().CustomResourceDefinitions().Informer()
+	if _, err := inf.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		DeleteFunc: invalidate,
+	}); err != nil {
+		return errors.Wrap(err, "cannot watch custom resource definitions")
+	}
+
+	f.Start(ctx.Done())
+	f.WaitForCacheSync(ctx.Done())
+
+	return nil
+}
+
+// instanceID identifies this process in traces and metrics. It prefers the
+// pod name supplied by Kubernetes' downward API (see the Deployment's env)
+// and falls back to the OS hostname when running outside a Pod.
+func instanceID() string {
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		return pod
+	}
+	h, _ := os.Hostname()
+	return h
+}
+
+// newOTLPSpanExporter creates a trace exporter that sends spans to endpoint
+// using either gRPC or HTTP, per protocol.
+func newOTLPSpanExporter(ctx context.Context, protocol, endpoint string, insecure bool, headers map[string]string) (*otlptrace.Exporter, error) {
+	switch protocol {
+	case "http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithHeaders(headers)}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptrace.New(ctx, otlptracehttp.NewClient(opts...))
+	default:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithHeaders(headers)}
+		if insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptrace.New(ctx, otlptracegrpc.NewClient(opts...))
+	}
+}
+
+// newOTLPMetricsPipeline installs a push-based metrics pipeline that exports
+// to an OTLP collector over gRPC, registering it as the global MeterProvider.
+// It returns a function that flushes and shuts the pipeline down.
+func newOTLPMetricsPipeline(ctx context.Context, res *resource.Resource, endpoint string, insecure bool, headers map[string]string) (func(), error) {
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(endpoint), otlpmetricgrpc.WithHeaders(headers)}
+	if insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+
+	exp, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create OTLP metric exporter")
+	}
+
+	cont := basic.New(
+		processor.NewFactory(simple.NewWithHistogramDistribution(), exp),
+		basic.WithExporter(exp),
+		basic.WithResource(res),
+	)
+	if err := cont.Start(ctx); err != nil {
+		return nil, errors.Wrap(err, "cannot start OTLP metric controller")
+	}
+	global.SetMeterProvider(cont)
+
+	return func() {
+		kingpin.FatalIfError(cont.Stop(context.Background()), "cannot shutdown OTLP metric exporter")
+	}, nil
+}
+
 type formatter struct{ log logging.Logger }
 
 func (f *formatter) NewLogEntry(r *http.Request) middleware.LogEntry {
@@ -0,0 +1,78 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth extracts the credentials our GraphQL API should use to talk
+// to the Kubernetes API on behalf of the caller.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const keyCredentials contextKey = "xgql-credentials"
+
+// Credentials are used to authenticate to the Kubernetes API on behalf of
+// whoever called our GraphQL API.
+type Credentials struct {
+	// Key uniquely identifies this set of Credentials. ClientCache shares a
+	// client between any two requests that produce the same Key.
+	Key string
+
+	// BearerToken is sent to the Kubernetes API server's Authorization
+	// header.
+	BearerToken string
+
+	// ImpersonateUser, if set, is sent to the Kubernetes API server as the
+	// impersonated username (i.e. the "--as" flag) instead of BearerToken.
+	ImpersonateUser string
+
+	// ImpersonateGroups, if set, is sent to the Kubernetes API server as the
+	// impersonated user's groups (i.e. the "--as-group" flag).
+	ImpersonateGroups []string
+}
+
+// FromContext extracts the Credentials Middleware attached to the supplied
+// context, if any.
+func FromContext(ctx context.Context) (Credentials, bool) {
+	c, ok := ctx.Value(keyCredentials).(Credentials)
+	return c, ok
+}
+
+// NewContext returns a copy of ctx carrying the supplied Credentials, ready
+// for FromContext to extract. Most callers get Credentials onto a context via
+// Middleware; this is for callers (e.g. a websocket InitFunc) that
+// authenticate a connection some other way.
+func NewContext(ctx context.Context, c Credentials) context.Context {
+	return withCredentials(ctx, c)
+}
+
+func withCredentials(ctx context.Context, c Credentials) context.Context {
+	return context.WithValue(ctx, keyCredentials, c)
+}
+
+// Middleware extracts a bearer token from each request's Authorization header
+// and attaches it to the request's context as Credentials, using the token
+// itself as both the cache key and the credential forwarded to the API
+// server. It's equivalent to NewMiddleware called with no Options.
+var Middleware = NewMiddleware()
+
+// bearerToken returns the bearer token in the supplied request's
+// Authorization header, if any.
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOIDCCredentials(t *testing.T) {
+	past := time.Now().Add(-1 * time.Hour)
+	future := time.Now().Add(1 * time.Hour)
+
+	cases := map[string]struct {
+		reason   string
+		o        *OIDC
+		subject  string
+		expiry   time.Time
+		audience []string
+		groups   []string
+		want     Credentials
+		wantErr  bool
+	}{
+		"Expired": {
+			reason:  "An expired token should be rejected, regardless of audience or impersonation config.",
+			o:       &OIDC{},
+			expiry:  past,
+			wantErr: true,
+		},
+		"WrongAudience": {
+			reason:   "A token whose audience doesn't include our configured audience should be rejected.",
+			o:        &OIDC{Audience: "want"},
+			expiry:   future,
+			audience: []string{"got"},
+			wantErr:  true,
+		},
+		"ForwardBearerToken": {
+			reason:  "With no Impersonation configured the caller's subject and token should be forwarded as-is.",
+			o:       &OIDC{},
+			subject: "alice",
+			expiry:  future,
+			want:    Credentials{Key: "alice", BearerToken: "cool-token"},
+		},
+		"Impersonate": {
+			reason:  "With Impersonation configured the token should be exchanged for impersonation headers, not forwarded.",
+			o:       &OIDC{Impersonation: &Impersonation{UserPrefix: "oidc:", GroupPrefix: "oidc:"}},
+			subject: "alice",
+			expiry:  future,
+			groups:  []string{"admins", "devs"},
+			want: Credentials{
+				Key:               "alice",
+				ImpersonateUser:   "oidc:alice",
+				ImpersonateGroups: []string{"oidc:admins", "oidc:devs"},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.o.credentials("cool-token", tc.subject, tc.expiry, tc.audience, tc.groups)
+
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("\n%s\ncredentials(...): wantErr: %t, gotErr: %v", tc.reason, tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\ncredentials(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestContainsString(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		in     []string
+		want   string
+		wantOk bool
+	}{
+		"Found": {
+			reason: "containsString should report true when want is present in in.",
+			in:     []string{"a", "b", "c"},
+			want:   "b",
+			wantOk: true,
+		},
+		"NotFound": {
+			reason: "containsString should report false when want is absent from in.",
+			in:     []string{"a", "b", "c"},
+			want:   "d",
+			wantOk: false,
+		},
+		"Empty": {
+			reason: "containsString should report false for an empty slice.",
+			wantOk: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := containsString(tc.in, tc.want); got != tc.wantOk {
+				t.Errorf("\n%s\ncontainsString(...): want: %t, got: %t", tc.reason, tc.wantOk, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clients creates and caches the Kubernetes clients our GraphQL API
+// uses to satisfy requests, one per caller identity.
+package clients
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+
+	"github.com/upbound/xgql/internal/auth"
+)
+
+const (
+	errCreateDiscoveryClient = "cannot create discovery client"
+	errCreateCache           = "cannot create client cache"
+	errCreateClient          = "cannot create client"
+	errSyncCache             = "timed out waiting for client cache to sync"
+
+	// cacheSyncTimeout bounds how long we'll wait for a new identity's cache
+	// to sync before giving up. Without this, creds that can never sync (e.g.
+	// because they lack RBAC access to watch something) would block forever -
+	// see newIdentity.
+	cacheSyncTimeout = 30 * time.Second
+)
+
+// Config returns a REST config for the Kubernetes API server, preferring
+// in-cluster configuration and falling back to the caller's kubeconfig.
+func Config() (*rest.Config, error) {
+	if cfg, err := rest.InClusterConfig(); err == nil {
+		return cfg, nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// RESTMapper returns a REST mapper that discovers the API resources offered
+// by the API server the supplied config points to. The returned mapper caches
+// what it discovers, but forgets everything when Reset is called - we do
+// this when a CRD is deleted, so API resources it used to offer aren't
+// resolved indefinitely from a stale cache.
+func RESTMapper(cfg *rest.Config) (meta.RESTMapper, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, errCreateDiscoveryClient)
+	}
+	return restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)), nil
+}
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// Anonymize strips credentials from the supplied REST config before it's
+// used as the template for every identity's client, so that nothing but the
+// caller's own Credentials can ever be sent to the API server.
+func Anonymize(cfg *rest.Config) Option {
+	anon := rest.AnonymousClientConfig(cfg)
+	return func(c *Cache) { c.rest = anon }
+}
+
+// WithRESTMapper configures the REST mapper new clients use to map GVKs to
+// GVRs.
+func WithRESTMapper(m meta.RESTMapper) Option {
+	return func(c *Cache) { c.mapper = m }
+}
+
+// DoNotCache configures the resources that clients will never cache.
+func DoNotCache(o []client.Object) Option {
+	return func(c *Cache) { c.noCache = o }
+}
+
+// WithLogger configures the logger used by the Cache and the clients it
+// creates.
+func WithLogger(l logging.Logger) Option {
+	return func(c *Cache) { c.log = l }
+}
+
+type identity struct {
+	client client.Client
+	cache  *trackedCache
+	cancel context.CancelFunc
+}
+
+// A Cache of Kubernetes clients, one per caller identity.
+type Cache struct {
+	scheme  *runtime.Scheme
+	rest    *rest.Config
+	mapper  meta.RESTMapper
+	noCache []client.Object
+	log     logging.Logger
+
+	mu sync.Mutex
+	id map[string]*identity
+
+	// creating tracks identities currently being built by newIdentity, so
+	// that concurrent callers for the *same* identity wait for the in-flight
+	// build rather than racing to create two caches for it. Unlike the old
+	// code, building an identity does not hold mu - see newIdentity.
+	creating map[string]chan struct{}
+}
+
+// NewCache returns a Cache of Kubernetes clients, one per caller identity.
+func NewCache(s *runtime.Scheme, opts ...Option) *Cache {
+	c := &Cache{
+		scheme:   s,
+		log:      logging.NewNopLogger(),
+		id:       make(map[string]*identity),
+		creating: make(map[string]chan struct{}),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Get a client for the supplied credentials, creating one (and the
+// controller-runtime cache backing it) if this is the first time we've seen
+// this identity.
+func (c *Cache) Get(creds auth.Credentials) (client.Client, error) {
+	id, err := c.identity(creds)
+	if err != nil {
+		return nil, err
+	}
+	return id.client, nil
+}
+
+// GetCache returns the controller-runtime cache backing the supplied
+// credentials' client, creating it (and the client) if necessary. Resolvers
+// use this to register their own informer event handlers, e.g. to serve
+// GraphQL Subscriptions.
+func (c *Cache) GetCache(creds auth.Credentials) (cache.Cache, error) {
+	id, err := c.identity(creds)
+	if err != nil {
+		return nil, err
+	}
+	return id.cache, nil
+}
+
+// identity returns the identity for the supplied credentials, building it if
+// this is the first time we've seen them. At most one identity is ever built
+// per key at a time - concurrent callers for the same key wait for the
+// in-flight build to finish rather than racing to build their own.
+func (c *Cache) identity(creds auth.Credentials) (*identity, error) {
+	for {
+		c.mu.Lock()
+		if id, ok := c.id[creds.Key]; ok {
+			c.mu.Unlock()
+			return id, nil
+		}
+		if ch, ok := c.creating[creds.Key]; ok {
+			c.mu.Unlock()
+			<-ch
+			continue
+		}
+		ch := make(chan struct{})
+		c.creating[creds.Key] = ch
+		c.mu.Unlock()
+
+		id, err := c.newIdentity(creds)
+
+		c.mu.Lock()
+		delete(c.creating, creds.Key)
+		if err == nil {
+			c.id[creds.Key] = id
+		}
+		c.mu.Unlock()
+		close(ch)
+
+		return id, err
+	}
+}
+
+// newIdentity builds (but does not register) the client and cache for the
+// supplied credentials. It intentionally does not hold c.mu - starting the
+// cache and waiting for it to sync can take a long time, or never return at
+// all (e.g. because creds lack RBAC access to watch something), and we must
+// not block every other identity - or the DefaultWatchErrorHandler callback
+// below, which itself calls InvalidateIdentity - behind that wait.
+func (c *Cache) newIdentity(creds auth.Credentials) (*identity, error) {
+	cfg := *c.rest
+	if creds.ImpersonateUser != "" {
+		cfg.Impersonate = rest.ImpersonationConfig{UserName: creds.ImpersonateUser, Groups: creds.ImpersonateGroups}
+	} else {
+		cfg.BearerToken = creds.BearerToken
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ca, err := cache.New(&cfg, cache.Options{
+		Scheme: c.scheme,
+		Mapper: c.mapper,
+
+		// NOTE(xgql): controller-runtime has no way to stop watching a
+		// single GVK, but it does let us handle watch errors ourselves. If
+		// creds turn out to be expired or lack RBAC access we tear down
+		// this identity's whole cache rather than let client-go log the
+		// same watch error indefinitely until the cache's TTL expires.
+		DefaultWatchErrorHandler: func(r *toolscache.Reflector, err error) {
+			if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+				c.InvalidateIdentity(creds)
+				return
+			}
+			toolscache.DefaultWatchErrorHandler(r, err)
+		},
+	})
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, errCreateCache)
+	}
+	tc := &trackedCache{Cache: ca, scheme: c.scheme}
+
+	go func() {
+		_ = ca.Start(ctx)
+	}()
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer syncCancel()
+	if !ca.WaitForCacheSync(syncCtx) {
+		cancel()
+		return nil, errors.New(errSyncCache)
+	}
+
+	cl, err := client.New(&cfg, client.Options{Scheme: c.scheme, Mapper: c.mapper, Cache: &client.CacheOptions{
+		Reader:     tc,
+		DisableFor: c.noCache,
+	}})
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, errCreateClient)
+	}
+
+	return &identity{client: cl, cache: tc, cancel: cancel}, nil
+}
+
+// Invalidate stops and discards the cache of every identity that's watching
+// the supplied GVK, by tearing down and rebuilding their whole cache -
+// controller-runtime has no way to stop watching a single GVK in place.
+// Identities that have never asked to read this GVK are left alone. It also
+// resets the shared REST mapper, so a GVK whose CRD was just deleted isn't
+// resolved from stale discovery data. Call this when a CRD offering the GVK
+// is deleted.
+func (c *Cache) Invalidate(gvk schema.GroupVersionKind) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, id := range c.id {
+		if !id.cache.Watches(gvk) {
+			continue
+		}
+		id.cancel()
+		delete(c.id, key)
+	}
+
+	if r, ok := c.mapper.(meta.ResettableRESTMapper); ok {
+		r.Reset()
+	}
+}
+
+// InvalidateIdentity tears down the supplied credentials' cached client and
+// cache, e.g. because a watch using them just failed with 401 or 403. A new
+// client and cache are created the next time these credentials call Get or
+// GetCache.
+func (c *Cache) InvalidateIdentity(creds auth.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := c.id[creds.Key]
+	if !ok {
+		return
+	}
+	id.cancel()
+	delete(c.id, creds.Key)
+}
+
+// A trackedCache is a cache.Cache that remembers which GVKs it's been asked
+// to read, so that Invalidate can tell whether tearing it down is actually
+// necessary for a given GVK rather than assuming every identity watches
+// everything.
+type trackedCache struct {
+	cache.Cache
+
+	scheme *runtime.Scheme
+
+	mu   sync.Mutex
+	gvks map[schema.GroupVersionKind]bool
+}
+
+// Get records the GVK of obj - most of our resolvers read through this path,
+// via the client.Client the cache backs - before delegating to the wrapped
+// cache.
+func (t *trackedCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if gvk, err := apiutil.GVKForObject(obj, t.scheme); err == nil {
+		t.track(gvk)
+	}
+	return t.Cache.Get(ctx, key, obj)
+}
+
+// List records the GVK that list's items are of before delegating to the
+// wrapped cache.
+func (t *trackedCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if gvk, err := apiutil.GVKForObject(list, t.scheme); err == nil {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+		t.track(gvk)
+	}
+	return t.Cache.List(ctx, list, opts...)
+}
+
+// GetInformer records the GVK of obj before delegating to the wrapped cache.
+// Subscription resolvers call this directly (via GetCache) to register their
+// own informer event handlers.
+func (t *trackedCache) GetInformer(ctx context.Context, obj client.Object, opts ...cache.InformerGetOption) (cache.Informer, error) {
+	if gvk, err := apiutil.GVKForObject(obj, t.scheme); err == nil {
+		t.track(gvk)
+	}
+	return t.Cache.GetInformer(ctx, obj, opts...)
+}
+
+// GetInformerForKind records gvk before delegating to the wrapped cache.
+func (t *trackedCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind, opts ...cache.InformerGetOption) (cache.Informer, error) {
+	t.track(gvk)
+	return t.Cache.GetInformerForKind(ctx, gvk, opts...)
+}
+
+func (t *trackedCache) track(gvk schema.GroupVersionKind) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.gvks == nil {
+		t.gvks = make(map[schema.GroupVersionKind]bool)
+	}
+	t.gvks[gvk] = true
+}
+
+// Watches returns true if this cache has ever been asked for an informer for
+// gvk, e.g. via a Get, List, or GetInformer(ForKind) call.
+func (t *trackedCache) Watches(gvk schema.GroupVersionKind) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gvks[gvk]
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/upbound/xgql/internal/auth"
+)
+
+func TestTrackedCacheWatches(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "example.org", Version: "v1", Kind: "Thing"}
+
+	tc := &trackedCache{}
+	if tc.Watches(gvk) {
+		t.Error("Watches: reported watching a GVK that was never tracked")
+	}
+
+	tc.track(gvk)
+	if !tc.Watches(gvk) {
+		t.Error("Watches: did not report watching a GVK that was tracked")
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	watched := schema.GroupVersionKind{Group: "example.org", Version: "v1", Kind: "Thing"}
+	other := schema.GroupVersionKind{Group: "example.org", Version: "v1", Kind: "Other"}
+
+	var cancelledWatcher, cancelledBystander bool
+
+	c := &Cache{
+		id: map[string]*identity{
+			"watcher": {
+				cache:  &trackedCache{gvks: map[schema.GroupVersionKind]bool{watched: true}},
+				cancel: func() { cancelledWatcher = true },
+			},
+			"bystander": {
+				cache:  &trackedCache{gvks: map[schema.GroupVersionKind]bool{other: true}},
+				cancel: func() { cancelledBystander = true },
+			},
+		},
+		creating: map[string]chan struct{}{},
+	}
+
+	c.Invalidate(watched)
+
+	if !cancelledWatcher {
+		t.Error("Invalidate(watched): identity watching the GVK was not cancelled")
+	}
+	if cancelledBystander {
+		t.Error("Invalidate(watched): identity not watching the GVK was unexpectedly cancelled")
+	}
+	if _, ok := c.id["watcher"]; ok {
+		t.Error("Invalidate(watched): identity watching the GVK was not removed from the cache")
+	}
+	if _, ok := c.id["bystander"]; !ok {
+		t.Error("Invalidate(watched): identity not watching the GVK was unexpectedly removed from the cache")
+	}
+}
+
+func TestCacheInvalidateIdentity(t *testing.T) {
+	var cancelled bool
+
+	c := &Cache{
+		id: map[string]*identity{
+			"a": {cache: &trackedCache{}, cancel: func() { cancelled = true }},
+		},
+		creating: map[string]chan struct{}{},
+	}
+
+	c.InvalidateIdentity(auth.Credentials{Key: "a"})
+
+	if !cancelled {
+		t.Error("InvalidateIdentity: identity was not cancelled")
+	}
+	if _, ok := c.id["a"]; ok {
+		t.Error("InvalidateIdentity: identity was not removed from the cache")
+	}
+
+	// Invalidating an identity we've never seen should be a no-op, not a
+	// panic.
+	c.InvalidateIdentity(auth.Credentials{Key: "does-not-exist"})
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	pkgv1beta1 "github.com/crossplane/crossplane/apis/pkg/v1beta1"
+)
+
+// A DeploymentRuntimeConfig templates the Deployment, ServiceAccount, and
+// Service Crossplane uses to run a Provider's controller.
+type DeploymentRuntimeConfig struct {
+	ID         ReferenceID
+	APIVersion string
+	Kind       string
+	Metadata   *ObjectMeta
+	Spec       *DeploymentRuntimeConfigSpec
+}
+
+// A DeploymentRuntimeConfigSpec templates a Provider's runtime.
+type DeploymentRuntimeConfigSpec struct {
+	// Replicas desired for the Provider controller's Deployment, if the
+	// template overrides the default.
+	Replicas *int32
+
+	// ServiceAccountName used to run the Provider's controller, if the
+	// template overrides the default.
+	ServiceAccountName *string
+}
+
+// GetDeploymentRuntimeConfig converts a Kubernetes DeploymentRuntimeConfig to
+// our model.
+func GetDeploymentRuntimeConfig(drc *pkgv1beta1.DeploymentRuntimeConfig) DeploymentRuntimeConfig {
+	out := DeploymentRuntimeConfig{
+		ID: ReferenceID{
+			APIVersion: drc.APIVersion,
+			Kind:       drc.Kind,
+			Name:       drc.GetName(),
+		},
+		APIVersion: drc.APIVersion,
+		Kind:       drc.Kind,
+		Metadata:   GetObjectMeta(drc),
+		Spec:       &DeploymentRuntimeConfigSpec{},
+	}
+
+	if dt := drc.Spec.DeploymentTemplate; dt != nil && dt.Spec != nil {
+		out.Spec.Replicas = dt.Spec.Replicas
+	}
+
+	if sat := drc.Spec.ServiceAccountTemplate; sat != nil && sat.Metadata != nil {
+		out.Spec.ServiceAccountName = sat.Metadata.Name
+	}
+
+	return out
+}
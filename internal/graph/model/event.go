@@ -0,0 +1,32 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// EventType enumerates the kinds of change a Subscription can report.
+type EventType string
+
+// Event types.
+const (
+	EventTypeAdded    EventType = "ADDED"
+	EventTypeModified EventType = "MODIFIED"
+	EventTypeDeleted  EventType = "DELETED"
+)
+
+// A ProviderRevisionEvent is emitted by a Subscription when a watched
+// ProviderRevision is added, modified, or deleted.
+type ProviderRevisionEvent struct {
+	Type             EventType
+	ProviderRevision ProviderRevision
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LabelSelectorOperator mirrors metav1.LabelSelectorOperator in our GraphQL
+// API, so that callers can express the same label match expressions they
+// could against the Kubernetes API.
+type LabelSelectorOperator string
+
+// Label selector operators. These mirror the LabelSelectorOperator enum
+// values in schema.graphql, which (being GraphQL enum values) are spelled
+// differently than their metav1.LabelSelectorOperator equivalents - see
+// toMetaOperator.
+const (
+	LabelSelectorOperatorIn           LabelSelectorOperator = "IN"
+	LabelSelectorOperatorNotIn        LabelSelectorOperator = "NOT_IN"
+	LabelSelectorOperatorExists       LabelSelectorOperator = "EXISTS"
+	LabelSelectorOperatorDoesNotExist LabelSelectorOperator = "DOES_NOT_EXIST"
+)
+
+// A LabelSelectorRequirement is a single label match expression, e.g.
+// "environment In (production, staging)".
+type LabelSelectorRequirement struct {
+	Key      string
+	Operator LabelSelectorOperator
+	Values   []string
+}
+
+// A LabelPair is a label key and value. We use a list of pairs rather than a
+// map because GraphQL input types have no map equivalent.
+type LabelPair struct {
+	Key   string
+	Value string
+}
+
+// A LabelSelector selects objects by their labels. It's equivalent to, and
+// convertible to, a Kubernetes metav1.LabelSelector.
+type LabelSelector struct {
+	MatchLabels      []*LabelPair
+	MatchExpressions []LabelSelectorRequirement
+}
+
+// A Filter narrows the set of objects returned by a Connection resolver. It's
+// accepted by every resolver that lists Kubernetes objects, and translated
+// into client.ListOptions so that filtering happens server-side (i.e. in the
+// controller-runtime cache) wherever Kubernetes supports it natively. Filter
+// criteria Kubernetes has no concept of - like NamePrefix - are instead
+// applied as a residual predicate by the resolver.
+type Filter struct {
+	// LabelSelector filters by the labels an object carries.
+	LabelSelector *LabelSelector
+
+	// FieldSelector filters by field values, e.g. metadata.namespace.
+	FieldSelector []*LabelPair
+
+	// NamePrefix filters by a prefix of the object's name. This has no
+	// Kubernetes server-side equivalent, so it's applied client-side.
+	NamePrefix *string
+}
+
+// ListOptions converts this Filter to the client.ListOptions controller-
+// runtime uses to query its cache.
+func (f *Filter) ListOptions() ([]client.ListOption, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	opts := make([]client.ListOption, 0, 2)
+
+	if f.LabelSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(toMetaLabelSelector(f.LabelSelector))
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse label selector")
+		}
+		opts = append(opts, client.MatchingLabelsSelector{Selector: s})
+	}
+
+	if len(f.FieldSelector) > 0 {
+		opts = append(opts, client.MatchingFieldsSelector{Selector: fields.SelectorFromSet(toStringMap(f.FieldSelector))})
+	}
+
+	return opts, nil
+}
+
+// Matches returns true if the supplied name satisfies this Filter's residual
+// (i.e. not server-side filterable) predicates.
+func (f *Filter) Matches(name string) bool {
+	if f == nil || f.NamePrefix == nil {
+		return true
+	}
+	return len(name) >= len(*f.NamePrefix) && name[:len(*f.NamePrefix)] == *f.NamePrefix
+}
+
+// LabelsSelector converts this Filter's LabelSelector to a labels.Selector,
+// for callers (e.g. Subscriptions) that must test it against an object
+// already in hand rather than push it down to the API server via
+// ListOptions. It returns labels.Everything() if this Filter has no
+// LabelSelector.
+func (f *Filter) LabelsSelector() (labels.Selector, error) {
+	if f == nil || f.LabelSelector == nil {
+		return labels.Everything(), nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(toMetaLabelSelector(f.LabelSelector))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse label selector")
+	}
+	return s, nil
+}
+
+func toMetaLabelSelector(s *LabelSelector) *metav1.LabelSelector {
+	out := &metav1.LabelSelector{MatchLabels: toStringMap(s.MatchLabels)}
+	for _, r := range s.MatchExpressions {
+		out.MatchExpressions = append(out.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      r.Key,
+			Operator: toMetaOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	return out
+}
+
+// toMetaOperator converts a GraphQL LabelSelectorOperator to its
+// metav1.LabelSelectorOperator equivalent. The two enumerate the same
+// operators, but spell them differently (e.g. "NOT_IN" vs "NotIn"), so a
+// direct cast produces an operator metav1.LabelSelectorAsSelector rejects as
+// invalid.
+func toMetaOperator(o LabelSelectorOperator) metav1.LabelSelectorOperator {
+	switch o {
+	case LabelSelectorOperatorNotIn:
+		return metav1.LabelSelectorOpNotIn
+	case LabelSelectorOperatorExists:
+		return metav1.LabelSelectorOpExists
+	case LabelSelectorOperatorDoesNotExist:
+		return metav1.LabelSelectorOpDoesNotExist
+	default:
+		return metav1.LabelSelectorOpIn
+	}
+}
+
+// toStringMap converts a list of LabelPairs to the map[string]string that
+// Kubernetes' label and field selector types expect.
+func toStringMap(pairs []*LabelPair) map[string]string {
+	if len(pairs) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		out[p.Key] = p.Value
+	}
+	return out
+}
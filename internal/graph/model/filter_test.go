@@ -0,0 +1,199 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFilterMatches(t *testing.T) {
+	prefix := "cool-"
+
+	cases := map[string]struct {
+		reason string
+		f      *Filter
+		name   string
+		want   bool
+	}{
+		"NilFilter": {
+			reason: "A nil Filter should match every name.",
+			f:      nil,
+			name:   "cool-resource",
+			want:   true,
+		},
+		"NoNamePrefix": {
+			reason: "A Filter with no NamePrefix should match every name.",
+			f:      &Filter{},
+			name:   "cool-resource",
+			want:   true,
+		},
+		"MatchesPrefix": {
+			reason: "A name that starts with NamePrefix should match.",
+			f:      &Filter{NamePrefix: &prefix},
+			name:   "cool-resource",
+			want:   true,
+		},
+		"DoesNotMatchPrefix": {
+			reason: "A name that doesn't start with NamePrefix should not match.",
+			f:      &Filter{NamePrefix: &prefix},
+			name:   "uncool-resource",
+			want:   false,
+		},
+		"ShorterThanPrefix": {
+			reason: "A name shorter than NamePrefix should not match.",
+			f:      &Filter{NamePrefix: &prefix},
+			name:   "c",
+			want:   false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.f.Matches(tc.name); got != tc.want {
+				t.Errorf("\n%s\nMatches(%q): want: %t, got: %t", tc.reason, tc.name, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterListOptions(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		f      *Filter
+		want   []client.ListOption
+	}{
+		"Nil": {
+			reason: "A nil Filter should produce no ListOptions.",
+			f:      nil,
+			want:   nil,
+		},
+		"Empty": {
+			reason: "A Filter with no selectors should produce no ListOptions.",
+			f:      &Filter{},
+			want:   []client.ListOption{},
+		},
+		"LabelSelector": {
+			reason: "A Filter's LabelSelector should become a MatchingLabelsSelector.",
+			f: &Filter{LabelSelector: &LabelSelector{
+				MatchLabels: []*LabelPair{{Key: "cool", Value: "true"}},
+			}},
+			want: []client.ListOption{client.MatchingLabels{"cool": "true"}},
+		},
+		"FieldSelector": {
+			reason: "A Filter's FieldSelector should become a MatchingFieldsSelector.",
+			f: &Filter{FieldSelector: []*LabelPair{
+				{Key: "metadata.namespace", Value: "default"},
+			}},
+			want: []client.ListOption{client.MatchingFields{"metadata.namespace": "default"}},
+		},
+		"MatchExpressions": {
+			reason: "A LabelSelectorRequirement's GraphQL operator should translate to the matching metav1 operator, not be cast directly.",
+			f: &Filter{LabelSelector: &LabelSelector{
+				MatchExpressions: []LabelSelectorRequirement{
+					{Key: "env", Operator: LabelSelectorOperatorNotIn, Values: []string{"prod"}},
+				},
+			}},
+			want: []client.ListOption{
+				client.MatchingLabelsSelector{Selector: mustRequirementSelector(t, "env", selection.NotIn, []string{"prod"})},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.f.ListOptions()
+			if err != nil {
+				t.Fatalf("\n%s\nListOptions(): unexpected error: %v", tc.reason, err)
+			}
+
+			// client.MatchingLabelsSelector and client.MatchingFieldsSelector
+			// wrap a labels.Selector/fields.Selector interface, which cmp
+			// can't compare directly - apply each option to a fresh
+			// ListOptions and compare the resulting selector strings instead.
+			wantOpts := &client.ListOptions{}
+			for _, o := range tc.want {
+				o.ApplyToList(wantOpts)
+			}
+			gotOpts := &client.ListOptions{}
+			for _, o := range got {
+				o.ApplyToList(gotOpts)
+			}
+
+			if diff := cmp.Diff(selectorStrings(wantOpts), selectorStrings(gotOpts)); diff != "" {
+				t.Errorf("\n%s\nListOptions(): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func mustRequirementSelector(t *testing.T, key string, op selection.Operator, vals []string) labels.Selector {
+	t.Helper()
+	req, err := labels.NewRequirement(key, op, vals)
+	if err != nil {
+		t.Fatalf("labels.NewRequirement(%q, %q, %v): %v", key, op, vals, err)
+	}
+	return labels.NewSelector().Add(*req)
+}
+
+func selectorStrings(o *client.ListOptions) [2]string {
+	var ls, fs string
+	if o.LabelSelector != nil {
+		ls = o.LabelSelector.String()
+	}
+	if o.FieldSelector != nil {
+		fs = o.FieldSelector.String()
+	}
+	return [2]string{ls, fs}
+}
+
+func TestToStringMap(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		pairs  []*LabelPair
+		want   map[string]string
+	}{
+		"Nil": {
+			reason: "A nil list of pairs should produce a nil map.",
+			pairs:  nil,
+			want:   nil,
+		},
+		"Empty": {
+			reason: "An empty list of pairs should produce a nil map.",
+			pairs:  []*LabelPair{},
+			want:   nil,
+		},
+		"Full": {
+			reason: "Every pair should become an entry in the returned map.",
+			pairs: []*LabelPair{
+				{Key: "cool", Value: "true"},
+				{Key: "uncool", Value: "false"},
+			},
+			want: map[string]string{"cool": "true", "uncool": "false"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, toStringMap(tc.pairs)); diff != "" {
+				t.Errorf("\n%s\ntoStringMap(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
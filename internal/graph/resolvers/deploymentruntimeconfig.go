@@ -0,0 +1,201 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolvers
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	pkgv1 "github.com/crossplane/crossplane/apis/pkg/v1"
+	pkgv1beta1 "github.com/crossplane/crossplane/apis/pkg/v1beta1"
+
+	"github.com/upbound/xgql/internal/auth"
+	"github.com/upbound/xgql/internal/graph/model"
+)
+
+const (
+	errGetProvider                 = "cannot get provider"
+	errGetProviderRev              = "cannot get provider revision"
+	errGetDeploymentRuntimeConfig  = "cannot get deployment runtime config"
+	errListProvidersForRTConfig    = "cannot list providers"
+	errListProviderRevsForRTConfig = "cannot list provider revisions"
+)
+
+type deploymentRuntimeConfig struct {
+	clients ClientCache
+}
+
+// Providers returns the Providers that reference this DeploymentRuntimeConfig
+// as their runtime configuration.
+func (r *deploymentRuntimeConfig) Providers(ctx context.Context, obj *model.DeploymentRuntimeConfig, f *model.Filter) (*model.ProviderConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	creds, _ := auth.FromContext(ctx)
+	c, err := r.clients.Get(creds)
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetClient))
+		return nil, nil
+	}
+
+	opts, err := f.ListOptions()
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errBadFilter))
+		return nil, nil
+	}
+
+	in := &pkgv1.ProviderList{}
+	if err := c.List(ctx, in, opts...); err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errListProvidersForRTConfig))
+		return nil, nil
+	}
+
+	out := &model.ProviderConnection{Nodes: make([]model.Provider, 0)}
+
+	for i := range in.Items {
+		p := in.Items[i] // So we don't take the address of a range variable.
+
+		ref := p.Spec.RuntimeConfigReference
+		if ref == nil || ref.Name != obj.Metadata.Name {
+			continue
+		}
+
+		if !f.Matches(p.GetName()) {
+			continue
+		}
+
+		out.Nodes = append(out.Nodes, model.GetProvider(&p))
+		out.TotalCount++
+	}
+
+	return out, nil
+}
+
+// ProviderRevisions returns the ProviderRevisions that reference this
+// DeploymentRuntimeConfig as their runtime configuration.
+func (r *deploymentRuntimeConfig) ProviderRevisions(ctx context.Context, obj *model.DeploymentRuntimeConfig, f *model.Filter) (*model.ProviderRevisionConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	creds, _ := auth.FromContext(ctx)
+	c, err := r.clients.Get(creds)
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetClient))
+		return nil, nil
+	}
+
+	opts, err := f.ListOptions()
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errBadFilter))
+		return nil, nil
+	}
+
+	in := &pkgv1.ProviderRevisionList{}
+	if err := c.List(ctx, in, opts...); err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errListProviderRevsForRTConfig))
+		return nil, nil
+	}
+
+	out := &model.ProviderRevisionConnection{Nodes: make([]model.ProviderRevision, 0)}
+
+	for i := range in.Items {
+		pr := in.Items[i] // So we don't take the address of a range variable.
+
+		ref := pr.Spec.RuntimeConfigReference
+		if ref == nil || ref.Name != obj.Metadata.Name {
+			continue
+		}
+
+		if !f.Matches(pr.GetName()) {
+			continue
+		}
+
+		out.Nodes = append(out.Nodes, model.GetProviderRevision(&pr))
+		out.TotalCount++
+	}
+
+	return out, nil
+}
+
+// RuntimeConfig returns the DeploymentRuntimeConfig referenced by a Provider,
+// if any.
+func (r *provider) RuntimeConfig(ctx context.Context, obj *model.Provider) (*model.DeploymentRuntimeConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	creds, _ := auth.FromContext(ctx)
+	c, err := r.clients.Get(creds)
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetClient))
+		return nil, nil
+	}
+
+	p := &pkgv1.Provider{}
+	if err := c.Get(ctx, types.NamespacedName{Name: obj.Metadata.Name}, p); err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetProvider))
+		return nil, nil
+	}
+
+	ref := p.Spec.RuntimeConfigReference
+	if ref == nil {
+		return nil, nil
+	}
+
+	drc := &pkgv1beta1.DeploymentRuntimeConfig{}
+	if err := c.Get(ctx, types.NamespacedName{Name: ref.Name}, drc); err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetDeploymentRuntimeConfig))
+		return nil, nil
+	}
+
+	out := model.GetDeploymentRuntimeConfig(drc)
+	return &out, nil
+}
+
+// RuntimeConfig returns the DeploymentRuntimeConfig referenced by a
+// ProviderRevision, if any.
+func (r *providerRevision) RuntimeConfig(ctx context.Context, obj *model.ProviderRevision) (*model.DeploymentRuntimeConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	creds, _ := auth.FromContext(ctx)
+	c, err := r.clients.Get(creds)
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetClient))
+		return nil, nil
+	}
+
+	pr := &pkgv1.ProviderRevision{}
+	if err := c.Get(ctx, types.NamespacedName{Name: obj.Metadata.Name}, pr); err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetProviderRev))
+		return nil, nil
+	}
+
+	ref := pr.Spec.RuntimeConfigReference
+	if ref == nil {
+		return nil, nil
+	}
+
+	drc := &pkgv1beta1.DeploymentRuntimeConfig{}
+	if err := c.Get(ctx, types.NamespacedName{Name: ref.Name}, drc); err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errGetDeploymentRuntimeConfig))
+		return nil, nil
+	}
+
+	out := model.GetDeploymentRuntimeConfig(drc)
+	return &out, nil
+}
@@ -21,6 +21,7 @@ import (
 const (
 	errListProviderRevs = "cannot list provider revisions"
 	errGetCRD           = "cannot get custom resource definition"
+	errBadFilter        = "cannot apply filter"
 )
 
 type provider struct {
@@ -37,7 +38,7 @@ func (r *provider) Events(ctx context.Context, obj *model.Provider) (*model.Even
 	})
 }
 
-func (r *provider) Revisions(ctx context.Context, obj *model.Provider, active *bool) (*model.ProviderRevisionConnection, error) {
+func (r *provider) Revisions(ctx context.Context, obj *model.Provider, active *bool, f *model.Filter) (*model.ProviderRevisionConnection, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -48,8 +49,14 @@ func (r *provider) Revisions(ctx context.Context, obj *model.Provider, active *b
 		return nil, nil
 	}
 
+	opts, err := f.ListOptions()
+	if err != nil {
+		graphql.AddError(ctx, errors.Wrap(err, errBadFilter))
+		return nil, nil
+	}
+
 	in := &pkgv1.ProviderRevisionList{}
-	if err := c.List(ctx, in); err != nil {
+	if err := c.List(ctx, in, opts...); err != nil {
 		graphql.AddError(ctx, errors.Wrap(err, errListProviderRevs))
 		return nil, nil
 	}
@@ -73,6 +80,12 @@ func (r *provider) Revisions(ctx context.Context, obj *model.Provider, active *b
 			continue
 		}
 
+		// The cache already applied any label or field selector; namePrefix
+		// has no Kubernetes equivalent, so we apply it here.
+		if !f.Matches(pr.GetName()) {
+			continue
+		}
+
 		out.Nodes = append(out.Nodes, model.GetProviderRevision(&pr))
 		out.TotalCount++
 	}
@@ -98,7 +111,7 @@ type providerRevisionStatus struct {
 	clients ClientCache
 }
 
-func (r *providerRevisionStatus) Objects(ctx context.Context, obj *model.ProviderRevisionStatus) (*model.KubernetesResourceConnection, error) {
+func (r *providerRevisionStatus) Objects(ctx context.Context, obj *model.ProviderRevisionStatus, f *model.Filter) (*model.KubernetesResourceConnection, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -124,6 +137,13 @@ func (r *providerRevisionStatus) Objects(ctx context.Context, obj *model.Provide
 			continue
 		}
 
+		// Each object reference is fetched individually by name, so there's
+		// no server-side list to push a label or field selector into - only
+		// namePrefix applies here.
+		if !f.Matches(ref.Name) {
+			continue
+		}
+
 		crd := &kextv1.CustomResourceDefinition{}
 		if err := c.Get(ctx, types.NamespacedName{Name: ref.Name}, crd); err != nil {
 			graphql.AddError(ctx, errors.Wrap(err, errGetCRD))
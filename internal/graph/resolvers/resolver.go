@@ -0,0 +1,106 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resolvers implements our GraphQL API.
+package resolvers
+
+import (
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/upbound/xgql/internal/auth"
+)
+
+// timeout applied to any resolver that talks to the Kubernetes API.
+const timeout = 10 * time.Second
+
+const errGetClient = "cannot get client"
+
+// A ClientCache mints and caches Kubernetes clients, one per identity.
+type ClientCache interface {
+	// Get a client for the supplied credentials.
+	Get(creds auth.Credentials) (client.Client, error)
+}
+
+// Option configures a RootResolver.
+type Option func(*RootResolver)
+
+// WithMaxSubscriptionsPerIdentity bounds how many concurrent GraphQL
+// subscriptions a single identity may hold open at once. A value of zero
+// means unlimited.
+func WithMaxSubscriptionsPerIdentity(max int) Option {
+	return func(r *RootResolver) { r.maxSubscriptions = max }
+}
+
+// New returns a set of GraphQL resolvers backed by the supplied ClientCache.
+func New(c ClientCache, opts ...Option) *RootResolver {
+	r := &RootResolver{clients: c, active: make(map[string]int)}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// RootResolver is the root of our GraphQL resolver tree.
+type RootResolver struct {
+	clients          ClientCache
+	maxSubscriptions int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// Provider resolves fields of the Provider type.
+func (r *RootResolver) Provider() *provider { return &provider{clients: r.clients} }
+
+// ProviderRevision resolves fields of the ProviderRevision type.
+func (r *RootResolver) ProviderRevision() *providerRevision {
+	return &providerRevision{clients: r.clients}
+}
+
+// ProviderRevisionStatus resolves fields of the ProviderRevisionStatus type.
+func (r *RootResolver) ProviderRevisionStatus() *providerRevisionStatus {
+	return &providerRevisionStatus{clients: r.clients}
+}
+
+// DeploymentRuntimeConfig resolves fields of the DeploymentRuntimeConfig type.
+func (r *RootResolver) DeploymentRuntimeConfig() *deploymentRuntimeConfig {
+	return &deploymentRuntimeConfig{clients: r.clients}
+}
+
+// Subscription resolves our Subscription root type.
+func (r *RootResolver) Subscription() *subscription {
+	return &subscription{clients: r.clients, limiter: r}
+}
+
+// acquire reserves one of key's subscription slots, returning false if doing
+// so would exceed maxSubscriptions. The returned func releases the slot, and
+// must be called exactly once.
+func (r *RootResolver) acquire(key string) (func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSubscriptions > 0 && r.active[key] >= r.maxSubscriptions {
+		return nil, false
+	}
+
+	r.active[key]++
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.active[key]--
+	}, true
+}
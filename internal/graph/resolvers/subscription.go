@@ -0,0 +1,165 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolvers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	pkgv1 "github.com/crossplane/crossplane/apis/pkg/v1"
+
+	"github.com/upbound/xgql/internal/auth"
+	"github.com/upbound/xgql/internal/graph/model"
+)
+
+const (
+	errGetCache           = "cannot get client cache"
+	errWatchProviderRevs  = "cannot watch provider revisions"
+	errTooManySubs        = "too many concurrent subscriptions"
+	errParseLabelSelector = "cannot parse label selector"
+	errFieldSelectorUnsup = "subscriptions do not support filtering by a field selector"
+
+	// subscriptionBuffer bounds how many events we'll queue for a slow
+	// subscriber before we drop it, so one lagging client can't block the
+	// informer that feeds every other subscriber of the same kind.
+	subscriptionBuffer = 64
+)
+
+// A CacheGetter is a ClientCache that can also expose the controller-runtime
+// cache backing a given identity's client, so a Subscription can register its
+// own informer event handlers on it.
+type CacheGetter interface {
+	GetCache(creds auth.Credentials) (cache.Cache, error)
+}
+
+// A subscriptionLimiter bounds how many subscriptions a single identity may
+// hold open concurrently.
+type subscriptionLimiter interface {
+	acquire(key string) (release func(), ok bool)
+}
+
+type subscription struct {
+	clients ClientCache
+	limiter subscriptionLimiter
+}
+
+// ProviderRevisions streams ADDED, MODIFIED, and DELETED events for
+// ProviderRevisions, optionally narrowed by f.
+func (r *subscription) ProviderRevisions(ctx context.Context, f *model.Filter) (<-chan *model.ProviderRevisionEvent, error) {
+	cg, ok := r.clients.(CacheGetter)
+	if !ok {
+		return nil, errors.New(errGetCache)
+	}
+
+	// We have no indexed object to evaluate a field selector against here -
+	// unlike ListOptions, which pushes it down to the API server - so we
+	// reject it rather than silently ignoring it.
+	if f != nil && len(f.FieldSelector) > 0 {
+		return nil, errors.New(errFieldSelectorUnsup)
+	}
+
+	sel, err := f.LabelsSelector()
+	if err != nil {
+		return nil, errors.Wrap(err, errParseLabelSelector)
+	}
+
+	creds, _ := auth.FromContext(ctx)
+
+	release, ok := r.limiter.acquire(creds.Key)
+	if !ok {
+		return nil, errors.New(errTooManySubs)
+	}
+
+	c, err := cg.GetCache(creds)
+	if err != nil {
+		release()
+		return nil, errors.Wrap(err, errGetCache)
+	}
+
+	inf, err := c.GetInformer(ctx, &pkgv1.ProviderRevision{})
+	if err != nil {
+		release()
+		return nil, errors.Wrap(err, errWatchProviderRevs)
+	}
+
+	out := make(chan *model.ProviderRevisionEvent, subscriptionBuffer)
+
+	// send and the teardown goroutine below both touch out, and send runs on
+	// the shared informer's own goroutine - potentially concurrently with
+	// teardown, since RemoveEventHandler doesn't guarantee no callback is
+	// still mid-dispatch. closed, guarded by mu, lets teardown signal send to
+	// stop before out is closed, so we never close(out) while a send could
+	// still be writing to it.
+	var mu sync.Mutex
+	closed := false
+
+	send := func(t model.EventType, o interface{}) {
+		pr, ok := o.(*pkgv1.ProviderRevision)
+		if !ok || !f.Matches(pr.GetName()) || !sel.Matches(labels.Set(pr.GetLabels())) {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+
+		select {
+		case out <- &model.ProviderRevisionEvent{Type: t, ProviderRevision: model.GetProviderRevision(pr)}:
+		default:
+			// The subscriber is lagging subscriptionBuffer events behind the
+			// informer. We drop the subscription, closing out, rather than
+			// block every other subscriber of this kind.
+			closed = true
+			close(out)
+		}
+	}
+
+	reg, err := inf.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(o interface{}) { send(model.EventTypeAdded, o) },
+		UpdateFunc: func(_, o interface{}) { send(model.EventTypeModified, o) },
+		DeleteFunc: func(o interface{}) { send(model.EventTypeDeleted, o) },
+	})
+	if err != nil {
+		release()
+		return nil, errors.Wrap(err, errWatchProviderRevs)
+	}
+
+	go func() {
+		<-ctx.Done()
+		// NOTE(xgql): This only removes our handler. controller-runtime has
+		// no way to stop watching a GVK outright once every subscriber of it
+		// has disconnected, so the informer itself keeps running until the
+		// cache behind it is invalidated (see clients.Cache.Invalidate) or the
+		// process exits.
+		_ = inf.RemoveEventHandler(reg)
+		release()
+
+		mu.Lock()
+		if !closed {
+			closed = true
+			close(out)
+		}
+		mu.Unlock()
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Upbound Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolvers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	pkgv1 "github.com/crossplane/crossplane/apis/pkg/v1"
+
+	"github.com/upbound/xgql/internal/auth"
+	"github.com/upbound/xgql/internal/graph/model"
+)
+
+// fakeRevisionCache is a cache.Cache that serves a single, real
+// SharedIndexInformer of ProviderRevisions, so ProviderRevisions can register
+// and remove a real event handler against it without talking to an API
+// server. Every other cache.Cache method panics if called, since
+// ProviderRevisions doesn't call them.
+type fakeRevisionCache struct {
+	cache.Cache
+	inf toolscache.SharedIndexInformer
+}
+
+func (f *fakeRevisionCache) GetInformer(_ context.Context, _ ctrlclient.Object, _ ...cache.InformerGetOption) (cache.Informer, error) {
+	return f.inf, nil
+}
+
+// fakeClientCache is a ClientCache and CacheGetter backed by a single
+// cache.Cache, regardless of the credentials it's asked for.
+type fakeClientCache struct {
+	c cache.Cache
+}
+
+func (f *fakeClientCache) Get(_ auth.Credentials) (ctrlclient.Client, error) { return nil, nil }
+func (f *fakeClientCache) GetCache(_ auth.Credentials) (cache.Cache, error)  { return f.c, nil }
+
+// newTestProviderRevisionInformer returns a real, running SharedIndexInformer
+// of ProviderRevisions backed by a fake watch.Interface, so tests can push
+// events through it without an API server. The caller must stop the
+// returned informer by closing the returned stop channel.
+func newTestProviderRevisionInformer(t *testing.T) (toolscache.SharedIndexInformer, *watch.FakeWatcher, chan struct{}) {
+	t.Helper()
+
+	w := watch.NewFake()
+	lw := &toolscache.ListWatch{
+		ListFunc: func(_ metav1.ListOptions) (runtime.Object, error) {
+			return &pkgv1.ProviderRevisionList{}, nil
+		},
+		WatchFunc: func(_ metav1.ListOptions) (watch.Interface, error) {
+			return w, nil
+		},
+	}
+	inf := toolscache.NewSharedIndexInformer(lw, &pkgv1.ProviderRevision{}, 0, toolscache.Indexers{})
+
+	stop := make(chan struct{})
+	go inf.Run(stop)
+	if !toolscache.WaitForCacheSync(stop, inf.HasSynced) {
+		t.Fatal("informer never synced")
+	}
+
+	return inf, w, stop
+}
+
+// TestProviderRevisionsConcurrentSendAndTeardown guards against a regression
+// where the teardown goroutine's close(out) could race a concurrent send,
+// panicking with "send on closed channel" - see send's closed flag.
+func TestProviderRevisionsConcurrentSendAndTeardown(t *testing.T) {
+	inf, w, stop := newTestProviderRevisionInformer(t)
+	defer close(stop)
+
+	r := &subscription{
+		clients: &fakeClientCache{c: &fakeRevisionCache{inf: inf}},
+		limiter: &RootResolver{active: make(map[string]int)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out, err := r.ProviderRevisions(ctx, nil)
+	if err != nil {
+		t.Fatalf("ProviderRevisions(...): unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			w.Add(&pkgv1.ProviderRevision{ObjectMeta: metav1.ObjectMeta{Name: "cool"}})
+		}
+	}()
+
+	// Cancel while events are still flowing, racing the teardown goroutine's
+	// close(out) against send - this used to panic.
+	time.Sleep(time.Millisecond)
+	cancel()
+
+	for range out { //nolint:revive // draining until close is the point of the test.
+	}
+	wg.Wait()
+}
+
+// TestProviderRevisionsLabelSelector guards against a regression where a
+// Filter's LabelSelector was silently ignored, so a subscriber received every
+// event regardless of the labels it asked to filter by.
+func TestProviderRevisionsLabelSelector(t *testing.T) {
+	inf, w, stop := newTestProviderRevisionInformer(t)
+	defer close(stop)
+
+	r := &subscription{
+		clients: &fakeClientCache{c: &fakeRevisionCache{inf: inf}},
+		limiter: &RootResolver{active: make(map[string]int)},
+	}
+
+	f := &model.Filter{LabelSelector: &model.LabelSelector{
+		MatchLabels: []*model.LabelPair{{Key: "cool", Value: "true"}},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, err := r.ProviderRevisions(ctx, f)
+	if err != nil {
+		t.Fatalf("ProviderRevisions(...): unexpected error: %v", err)
+	}
+
+	w.Add(&pkgv1.ProviderRevision{ObjectMeta: metav1.ObjectMeta{Name: "uncool", Labels: map[string]string{"cool": "false"}}})
+	w.Add(&pkgv1.ProviderRevision{ObjectMeta: metav1.ObjectMeta{Name: "cool", Labels: map[string]string{"cool": "true"}}})
+
+	got := <-out
+	if got.ProviderRevision.Metadata.Name != "cool" {
+		t.Fatalf("ProviderRevisions(...): want event for %q, got event for %q", "cool", got.ProviderRevision.Metadata.Name)
+	}
+}
+
+// TestProviderRevisionsFieldSelectorUnsupported guards against a regression
+// where a Filter's FieldSelector was silently ignored rather than rejected,
+// since Subscriptions have no indexed object to evaluate one against.
+func TestProviderRevisionsFieldSelectorUnsupported(t *testing.T) {
+	inf, _, stop := newTestProviderRevisionInformer(t)
+	defer close(stop)
+
+	r := &subscription{
+		clients: &fakeClientCache{c: &fakeRevisionCache{inf: inf}},
+		limiter: &RootResolver{active: make(map[string]int)},
+	}
+
+	f := &model.Filter{FieldSelector: []*model.LabelPair{{Key: "metadata.namespace", Value: "default"}}}
+
+	if _, err := r.ProviderRevisions(context.Background(), f); err == nil {
+		t.Fatal("ProviderRevisions(...): want error for unsupported field selector, got nil")
+	}
+}